@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/vapourismo/knx-go/knx/encoding"
 	"github.com/vapourismo/knx-go/knx/util"
@@ -60,11 +61,59 @@ var (
 	ErrUnknownService = errors.New("Unknown service identifier")
 )
 
-type serviceUnpackable interface {
+// ServiceUnpackable combines Unpackable and Service. Types that implement it can be registered
+// against a ServiceID via RegisterService, so that Unpack is able to produce them.
+type ServiceUnpackable interface {
 	util.Unpackable
 	Service
 }
 
+// ServiceFactory produces a new, empty instance of a service, ready to have Unpack called on it.
+type ServiceFactory func() ServiceUnpackable
+
+var (
+	serviceRegistryMu sync.RWMutex
+	serviceRegistry   = map[ServiceID]ServiceFactory{}
+)
+
+// RegisterService associates a ServiceID with a factory for the matching service type, so that
+// Unpack can produce it. Registering a factory for a ServiceID that is already registered
+// replaces the previous one. RegisterService may be called from multiple goroutines.
+func RegisterService(id ServiceID, factory ServiceFactory) {
+	serviceRegistryMu.Lock()
+	defer serviceRegistryMu.Unlock()
+
+	serviceRegistry[id] = factory
+}
+
+// RegisteredServices returns the ServiceIDs that currently have a factory registered, in no
+// particular order.
+func RegisteredServices() []ServiceID {
+	serviceRegistryMu.RLock()
+	defer serviceRegistryMu.RUnlock()
+
+	ids := make([]ServiceID, 0, len(serviceRegistry))
+	for id := range serviceRegistry {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func init() {
+	RegisterService(ConnReqService, func() ServiceUnpackable { return &ConnReq{} })
+	RegisterService(ConnResService, func() ServiceUnpackable { return &ConnRes{} })
+	RegisterService(ConnStateReqService, func() ServiceUnpackable { return &ConnStateReq{} })
+	RegisterService(ConnStateResService, func() ServiceUnpackable { return &ConnStateRes{} })
+	RegisterService(DiscReqService, func() ServiceUnpackable { return &DiscReq{} })
+	RegisterService(DiscResService, func() ServiceUnpackable { return &DiscRes{} })
+	RegisterService(TunnelReqService, func() ServiceUnpackable { return &TunnelReq{} })
+	RegisterService(TunnelResService, func() ServiceUnpackable { return &TunnelRes{} })
+	RegisterService(RoutingIndService, func() ServiceUnpackable { return &RoutingInd{} })
+	RegisterService(RoutingLostService, func() ServiceUnpackable { return &RoutingLost{} })
+	RegisterService(RoutingBusyService, func() ServiceUnpackable { return &RoutingBusy{} })
+}
+
 // Unpack parses a KNXnet/IP packet and retrieves its service payload.
 //
 // On success, the variable pointed to by srv will contain a pointer to a service type.
@@ -87,6 +136,7 @@ type serviceUnpackable interface {
 // 		// ...
 // 	}
 //
+// The set of services that Unpack recognizes can be extended with RegisterService.
 func Unpack(data []byte, srv *Service) (uint, error) {
 	var headerLen, version uint8
 	var srvID ServiceID
@@ -105,45 +155,16 @@ func Unpack(data []byte, srv *Service) (uint, error) {
 		return n, ErrHeaderVersion
 	}
 
-	var body serviceUnpackable
-	switch srvID {
-	case ConnReqService:
-		body = &ConnReq{}
-
-	case ConnResService:
-		body = &ConnRes{}
-
-	case ConnStateReqService:
-		body = &ConnStateReq{}
-
-	case ConnStateResService:
-		body = &ConnStateRes{}
-
-	case DiscReqService:
-		body = &DiscReq{}
-
-	case DiscResService:
-		body = &DiscRes{}
+	serviceRegistryMu.RLock()
+	factory, known := serviceRegistry[srvID]
+	serviceRegistryMu.RUnlock()
 
-	case TunnelReqService:
-		body = &TunnelReq{}
-
-	case TunnelResService:
-		body = &TunnelRes{}
-
-	case RoutingIndService:
-		body = &RoutingInd{}
-
-	case RoutingLostService:
-		body = &RoutingLost{}
-
-	case RoutingBusyService:
-		body = &RoutingBusy{}
-
-	default:
+	if !known {
 		return n, ErrUnknownService
 	}
 
+	body := factory()
+
 	m, err := body.Unpack(data[n:])
 
 	if err == nil {
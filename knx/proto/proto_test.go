@@ -0,0 +1,75 @@
+package proto
+
+import (
+	"testing"
+)
+
+type testUnpackableService struct {
+	id ServiceID
+}
+
+func (s *testUnpackableService) Service() ServiceID {
+	return s.id
+}
+
+func (s *testUnpackableService) Unpack(data []byte) (uint, error) {
+	return 0, nil
+}
+
+func TestRegisterServiceOverridesExistingFactory(t *testing.T) {
+	const id ServiceID = 0xffff
+
+	RegisterService(id, func() ServiceUnpackable { return &testUnpackableService{id: id} })
+	defer RegisterService(id, nil)
+
+	var found bool
+	for _, registered := range RegisteredServices() {
+		if registered == id {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("RegisteredServices() = %v, want it to contain %v", RegisteredServices(), id)
+	}
+
+	var calls int
+	RegisterService(id, func() ServiceUnpackable {
+		calls++
+		return &testUnpackableService{id: id}
+	})
+
+	data := []byte{6, 16, byte(id >> 8), byte(id), 0, 6}
+	var srv Service
+
+	if _, err := Unpack(data, &srv); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("factory called %d times, want 1", calls)
+	}
+
+	if _, ok := srv.(*testUnpackableService); !ok {
+		t.Fatalf("Unpack produced %T, want *testUnpackableService", srv)
+	}
+}
+
+func TestUnpackUnknownService(t *testing.T) {
+	data := []byte{6, 16, 0xff, 0xfe, 0, 6}
+	var srv Service
+
+	if _, err := Unpack(data, &srv); err != ErrUnknownService {
+		t.Fatalf("Unpack: got %v, want %v", err, ErrUnknownService)
+	}
+}
+
+func TestUnpackRejectsBadHeader(t *testing.T) {
+	if _, err := Unpack([]byte{7, 16, 0x02, 0x09, 0, 6}, new(Service)); err != ErrHeaderLength {
+		t.Fatalf("Unpack with bad header length: got %v, want %v", err, ErrHeaderLength)
+	}
+
+	if _, err := Unpack([]byte{6, 17, 0x02, 0x09, 0, 6}, new(Service)); err != ErrHeaderVersion {
+		t.Fatalf("Unpack with bad version: got %v, want %v", err, ErrHeaderVersion)
+	}
+}
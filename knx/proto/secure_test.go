@@ -0,0 +1,212 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplayWindowAcceptFirstSequence(t *testing.T) {
+	var rw replayWindow
+
+	if !rw.accept(0, 8) {
+		t.Fatal("expected the first frame of a session to be accepted regardless of its sequence number")
+	}
+
+	if rw.accept(0, 8) {
+		t.Fatal("expected a repeated sequence number to be rejected as a replay")
+	}
+}
+
+func TestReplayWindowAcceptStrictlyIncreasing(t *testing.T) {
+	var rw replayWindow
+
+	rw.accept(5, 0)
+
+	if rw.accept(4, 0) {
+		t.Fatal("expected a size-0 window to reject anything but a strictly increasing sequence number")
+	}
+
+	if !rw.accept(6, 0) {
+		t.Fatal("expected a strictly increasing sequence number to be accepted")
+	}
+}
+
+func TestReplayWindowAcceptWithinWindow(t *testing.T) {
+	var rw replayWindow
+
+	rw.accept(10, 4)
+
+	if !rw.accept(8, 4) {
+		t.Fatal("expected a sequence number within the window to be accepted")
+	}
+
+	if rw.accept(8, 4) {
+		t.Fatal("expected a repeated sequence number within the window to be rejected")
+	}
+
+	if rw.accept(5, 4) {
+		t.Fatal("expected a sequence number outside the window to be rejected")
+	}
+}
+
+func TestReplayWindowAcceptClampsOversizedWindow(t *testing.T) {
+	var rw replayWindow
+
+	rw.accept(100, 1000)
+
+	if !rw.accept(100-63, 1000) {
+		t.Fatal("expected the window to be usable up to its clamped maximum of 63")
+	}
+
+	if rw.accept(100-64, 1000) {
+		t.Fatal("expected the window to be clamped to 63, rejecting anything further behind")
+	}
+}
+
+func TestSecureWrapperUnpackUnderflow(t *testing.T) {
+	w := SecureWrapper{}
+
+	// Not even enough data for the fixed-size fields, let alone the trailing MAC.
+	if _, err := w.Unpack([]byte{0, 0}); err == nil {
+		t.Fatal("expected an error when unpacking a truncated SecureWrapper")
+	}
+}
+
+func TestSecureWrapperUnpackMissingMAC(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	full := SecureWrapper{
+		SessionID:    1,
+		SerialNumber: [6]byte{1, 2, 3, 4, 5, 6},
+		MessageTag:   2,
+	}
+
+	if _, err := full.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	// Strip the MAC that WriteTo appended, so the payload underflows again.
+	data := buf.Bytes()
+	data = data[:len(data)-16]
+
+	w := SecureWrapper{}
+	if _, err := w.Unpack(data); err == nil {
+		t.Fatal("expected an error when the trailing MAC is missing")
+	}
+}
+
+func TestSecureWrapperRoundTrip(t *testing.T) {
+	in := SecureWrapper{
+		SessionID:      7,
+		SequenceNumber: 0x010203040506,
+		SerialNumber:   [6]byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF},
+		MessageTag:     9,
+		EncryptedFrame: []byte{1, 2, 3, 4},
+		MAC:            [16]byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}
+
+	buf := bytes.Buffer{}
+	if _, err := in.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	out := SecureWrapper{}
+	if _, err := out.Unpack(buf.Bytes()); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	if out.SessionID != in.SessionID || out.SequenceNumber != in.SequenceNumber ||
+		out.SerialNumber != in.SerialNumber || out.MessageTag != in.MessageTag ||
+		!bytes.Equal(out.EncryptedFrame, in.EncryptedFrame) || out.MAC != in.MAC {
+		t.Fatalf("Unpack(WriteTo(in)) = %+v, want %+v", out, in)
+	}
+}
+
+func newTestSessionPair(t *testing.T) (client, server *SecureSession) {
+	t.Helper()
+
+	cfg := SecureConfig{
+		DeviceAuthCode: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		UserKey:        [16]byte{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+		UserID:         1,
+		SerialNumber:   [6]byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF},
+	}
+
+	client, err := NewSecureSession(cfg)
+	if err != nil {
+		t.Fatalf("client: NewSecureSession: %v", err)
+	}
+
+	server, err = NewSecureSession(cfg)
+	if err != nil {
+		t.Fatalf("server: NewSecureSession: %v", err)
+	}
+
+	if err := client.DeriveSessionKey(server.PublicKey()); err != nil {
+		t.Fatalf("client: DeriveSessionKey: %v", err)
+	}
+
+	if err := server.DeriveSessionKey(client.PublicKey()); err != nil {
+		t.Fatalf("server: DeriveSessionKey: %v", err)
+	}
+
+	res := SessionResponse{PublicKey: server.PublicKey(), MAC: server.SignSessionResponse(client.PublicKey())}
+	if err := client.VerifySessionResponse(&res, client.PublicKey()); err != nil {
+		t.Fatalf("client: VerifySessionResponse: %v", err)
+	}
+
+	auth := SessionAuthenticate{UserID: cfg.UserID, MAC: client.SignSessionAuthenticate(server.PublicKey())}
+	if err := server.VerifySessionAuthenticate(&auth, client.PublicKey()); err != nil {
+		t.Fatalf("server: VerifySessionAuthenticate: %v", err)
+	}
+
+	return client, server
+}
+
+func TestSecureSessionWrapUnwrapRoundTrip(t *testing.T) {
+	client, server := newTestSessionPair(t)
+
+	frame := []byte("hello, secure world")
+
+	wrapped, err := client.Wrap(frame, 42)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	got, err := server.Unwrap(wrapped)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+
+	if !bytes.Equal(got, frame) {
+		t.Fatalf("Unwrap returned %q, want %q", got, frame)
+	}
+}
+
+func TestSecureSessionWrapRequiresAuthentication(t *testing.T) {
+	session, err := NewSecureSession(SecureConfig{})
+	if err != nil {
+		t.Fatalf("NewSecureSession: %v", err)
+	}
+
+	if _, err := session.Wrap([]byte("data"), 0); err != ErrSecureNotAuthenticated {
+		t.Fatalf("Wrap on an unauthenticated session: got %v, want %v", err, ErrSecureNotAuthenticated)
+	}
+}
+
+func TestSecureSessionUnwrapRejectsReplay(t *testing.T) {
+	client, server := newTestSessionPair(t)
+
+	wrapped, err := client.Wrap([]byte("hello"), 0)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if _, err := server.Unwrap(wrapped); err != nil {
+		t.Fatalf("first Unwrap: %v", err)
+	}
+
+	if _, err := server.Unwrap(wrapped); err != ErrSecureReplay {
+		t.Fatalf("replayed Unwrap: got %v, want %v", err, ErrSecureReplay)
+	}
+}
@@ -0,0 +1,106 @@
+package proto
+
+import (
+	"context"
+	"io"
+)
+
+// Span represents a single traced Pack or Unpack operation. Finish must be called exactly once,
+// with the error (if any) produced by the traced operation.
+type Span interface {
+	// SetAttribute attaches additional information to the span, such as "header.length" or
+	// "body.bytes".
+	SetAttribute(key string, value interface{})
+
+	// Finish completes the span. err is the outcome of the traced operation, or nil on success.
+	Finish(err error)
+}
+
+// Tracer creates Spans around Pack and Unpack operations. StartPack and StartUnpack each return
+// a context derived from the one passed in; callers that perform further traced Pack/Unpack
+// calls as part of the same logical operation should use that derived context, so a Tracer
+// implementation can correlate the resulting spans.
+type Tracer interface {
+	// StartPack starts a Span around packing a service with the given ServiceID. It may return
+	// a derived context which subsequent operations should use to keep the span active.
+	StartPack(ctx context.Context, id ServiceID) (context.Context, Span)
+
+	// StartUnpack starts a Span around unpacking a packet. It may return a derived context
+	// which subsequent operations should use to keep the span active.
+	StartUnpack(ctx context.Context) (context.Context, Span)
+}
+
+// noopSpan is a Span that discards everything. It backs noopTracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+func (noopSpan) Finish(error) {}
+
+// noopTracer is the default Tracer. It preserves the behavior of Pack and Unpack as if no
+// tracing had been configured.
+type noopTracer struct{}
+
+func (noopTracer) StartPack(ctx context.Context, _ ServiceID) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) StartUnpack(ctx context.Context) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// DefaultTracer is the Tracer used by PackContext and UnpackContext when ctx does not carry one
+// of its own. It is a no-op tracer by default.
+var DefaultTracer Tracer = noopTracer{}
+
+type tracerContextKey struct{}
+
+// ContextWithTracer returns a copy of ctx that carries t. PackContext and UnpackContext calls
+// made with the returned context will report spans to t instead of DefaultTracer.
+func ContextWithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerContextKey{}, t)
+}
+
+func tracerFromContext(ctx context.Context) Tracer {
+	if t, ok := ctx.Value(tracerContextKey{}).(Tracer); ok {
+		return t
+	}
+
+	return DefaultTracer
+}
+
+// PackContext is like Pack, but traces the operation using the Tracer configured on ctx (see
+// ContextWithTracer), or DefaultTracer if none was configured.
+func PackContext(ctx context.Context, w io.Writer, srv ServiceWriterTo) (int64, error) {
+	_, span := tracerFromContext(ctx).StartPack(ctx, srv.Service())
+
+	span.SetAttribute("service.id", srv.Service())
+	span.SetAttribute("header.length", uint8(6))
+
+	n, err := Pack(w, srv)
+
+	span.SetAttribute("total.length", n)
+	span.Finish(err)
+
+	return n, err
+}
+
+// UnpackContext is like Unpack, but traces the operation using the Tracer configured on ctx (see
+// ContextWithTracer), or DefaultTracer if none was configured.
+func UnpackContext(ctx context.Context, data []byte, srv *Service) (uint, error) {
+	_, span := tracerFromContext(ctx).StartUnpack(ctx)
+
+	span.SetAttribute("header.length", uint8(6))
+	span.SetAttribute("body.bytes", len(data))
+
+	n, err := Unpack(data, srv)
+
+	if err == nil && *srv != nil {
+		span.SetAttribute("service.id", (*srv).Service())
+	}
+
+	span.SetAttribute("total.length", n)
+	span.Finish(err)
+
+	return n, err
+}
@@ -0,0 +1,105 @@
+package proto
+
+import (
+	"context"
+	"testing"
+)
+
+type recordingSpan struct {
+	attrs    map[string]interface{}
+	finished bool
+	err      error
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordingSpan) Finish(err error) {
+	s.finished = true
+	s.err = err
+}
+
+type recordingTracer struct {
+	packs   []*recordingSpan
+	unpacks []*recordingSpan
+}
+
+func (t *recordingTracer) StartPack(ctx context.Context, id ServiceID) (context.Context, Span) {
+	span := &recordingSpan{attrs: map[string]interface{}{}}
+	t.packs = append(t.packs, span)
+	return ctx, span
+}
+
+func (t *recordingTracer) StartUnpack(ctx context.Context) (context.Context, Span) {
+	span := &recordingSpan{attrs: map[string]interface{}{}}
+	t.unpacks = append(t.unpacks, span)
+	return ctx, span
+}
+
+func TestNoopTracerIsTheDefault(t *testing.T) {
+	if _, ok := DefaultTracer.(noopTracer); !ok {
+		t.Fatalf("DefaultTracer = %T, want noopTracer", DefaultTracer)
+	}
+}
+
+func TestTracerFromContextFallsBackToDefault(t *testing.T) {
+	if got := tracerFromContext(context.Background()); got != DefaultTracer {
+		t.Fatalf("tracerFromContext(context.Background()) = %v, want DefaultTracer", got)
+	}
+
+	tracer := &recordingTracer{}
+	ctx := ContextWithTracer(context.Background(), tracer)
+
+	if got := tracerFromContext(ctx); got != Tracer(tracer) {
+		t.Fatalf("tracerFromContext(ctx) = %v, want %v", got, tracer)
+	}
+}
+
+func TestPackContextReportsSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	ctx := ContextWithTracer(context.Background(), tracer)
+
+	srv := &ConnRes{}
+	if _, err := PackContext(ctx, new(discardWriter), srv); err != nil {
+		t.Fatalf("PackContext: %v", err)
+	}
+
+	if len(tracer.packs) != 1 {
+		t.Fatalf("got %d pack spans, want 1", len(tracer.packs))
+	}
+
+	span := tracer.packs[0]
+	if !span.finished || span.err != nil {
+		t.Fatalf("span = %+v, want finished with no error", span)
+	}
+
+	if span.attrs["service.id"] != srv.Service() {
+		t.Fatalf("span.attrs[service.id] = %v, want %v", span.attrs["service.id"], srv.Service())
+	}
+}
+
+func TestUnpackContextReportsErrors(t *testing.T) {
+	tracer := &recordingTracer{}
+	ctx := ContextWithTracer(context.Background(), tracer)
+
+	var srv Service
+	if _, err := UnpackContext(ctx, []byte{7, 16}, &srv); err == nil {
+		t.Fatal("expected an error unpacking a truncated packet")
+	}
+
+	if len(tracer.unpacks) != 1 {
+		t.Fatalf("got %d unpack spans, want 1", len(tracer.unpacks))
+	}
+
+	span := tracer.unpacks[0]
+	if !span.finished || span.err == nil {
+		t.Fatalf("span = %+v, want finished with an error", span)
+	}
+}
+
+type discardWriter struct{}
+
+func (*discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}